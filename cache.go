@@ -0,0 +1,108 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func buildCacheKey(route *resolvedRoute) (string, error) {
+	h := sha256.New()
+
+	io.WriteString(h, "tags:"+route.Tags+"\n")
+	io.WriteString(h, "target:"+route.Target+"\n")
+	io.WriteString(h, "compiler:"+route.Compiler+"\n")
+	io.WriteString(h, "opt:"+*flagTinygoOpt+"\n")
+	io.WriteString(h, "scheduler:"+*flagTinygoScheduler+"\n")
+	io.WriteString(h, "gc:"+*flagTinygoGC+"\n")
+
+	var files []string
+	root := route.root()
+	if _, err := os.Stat(root); err != nil {
+		io.WriteString(h, "package:"+route.Package+"\n")
+	} else if err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && p != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".go", ".mod", ".sum":
+			files = append(files, p)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		if err := hashFileInto(h, f); err != nil {
+			return "", err
+		}
+	}
+
+	if route.Overlay != "" {
+		if err := hashFileInto(h, route.Overlay); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFileInto(h io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	io.WriteString(h, name+"\n")
+	_, err = io.Copy(h, f)
+	return err
+}
+
+func buildCachedWasm(route *resolvedRoute, output string) (string, os.FileInfo, error) {
+	key, err := buildCacheKey(route)
+	if err != nil {
+		return "", nil, err
+	}
+	wasmPath := filepath.Join(output, key+".wasm")
+
+	if info, err := os.Stat(wasmPath); err == nil {
+		return wasmPath, info, nil
+	}
+
+	if err := runBuild(route, wasmPath); err != nil {
+		return "", nil, err
+	}
+
+	info, err := os.Stat(wasmPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return wasmPath, info, nil
+}
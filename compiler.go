@@ -0,0 +1,126 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	flagCompiler        = flag.String("compiler", "go", "Compiler toolchain used to build main.wasm: go or tinygo")
+	flagTinygoOpt       = flag.String("opt", "", "TinyGo -opt flag (only used when -compiler=tinygo)")
+	flagTinygoScheduler = flag.String("scheduler", "", "TinyGo -scheduler flag (only used when -compiler=tinygo)")
+	flagTinygoGC        = flag.String("gc", "", "TinyGo -gc flag (only used when -compiler=tinygo)")
+)
+
+func runBuild(route *resolvedRoute, wasmPath string) error {
+	switch route.Compiler {
+	case "", "go":
+		return runGoBuild(route, wasmPath)
+	case "tinygo":
+		return runTinygoBuild(route, wasmPath)
+	default:
+		return errors.New("wasmserve: unknown -compiler: " + route.Compiler)
+	}
+}
+
+func runGoBuild(route *resolvedRoute, wasmPath string) error {
+	// `go run -exec cp <pkg> <output>` is used instead of the equivalent `go build -o <output> <pkg>`
+	// This is to support path@version syntax.
+	// A combination of GOBIN and `go install` would not work due to:
+	// go: cannot install cross-compiled binaries when GOBIN is set
+	exc, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"run", "-exec", exc}
+	if route.Tags != "" {
+		args = append(args, "-tags", route.Tags)
+	}
+	if route.Overlay != "" {
+		args = append(args, "-overlay", route.Overlay)
+	}
+	args = append(args, route.Package, wasmPath)
+
+	log.Print("go ", strings.Join(args, " "))
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOOS="+routeGOOS(route), "GOARCH=wasm", "WASMSERVE=cp")
+	cmd.Dir = "."
+	return runAndLog(cmd)
+}
+
+func runTinygoBuild(route *resolvedRoute, wasmPath string) error {
+	args := []string{"build", "-o", wasmPath, "-target=" + tinygoTarget(route)}
+	if route.Tags != "" {
+		args = append(args, "-tags", route.Tags)
+	}
+	if *flagTinygoOpt != "" {
+		args = append(args, "-opt", *flagTinygoOpt)
+	}
+	if *flagTinygoScheduler != "" {
+		args = append(args, "-scheduler", *flagTinygoScheduler)
+	}
+	if *flagTinygoGC != "" {
+		args = append(args, "-gc", *flagTinygoGC)
+	}
+	args = append(args, route.Package)
+
+	log.Print("tinygo ", strings.Join(args, " "))
+	cmd := exec.Command("tinygo", args...)
+	cmd.Dir = "."
+	return runAndLog(cmd)
+}
+
+func tinygoTarget(route *resolvedRoute) string {
+	if route.Target == "wasip1" {
+		return "wasip1"
+	}
+	return "wasm"
+}
+
+func runAndLog(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Print(err)
+		log.Print(string(out))
+		return errors.New(string(out))
+	}
+	if len(out) > 0 {
+		log.Print(string(out))
+	}
+	return nil
+}
+
+func wasmExecJS(route *resolvedRoute) (string, error) {
+	if route.Compiler == "tinygo" {
+		out, err := exec.Command("tinygo", "env", "TINYGOROOT").Output()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(strings.TrimSpace(string(out)), "targets", "wasm_exec.js"), nil
+	}
+
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), "misc", "wasm", "wasm_exec.js"), nil
+}
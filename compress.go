@@ -0,0 +1,112 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+var flagCompress = flag.String("compress", "auto", "Pre-compress main.wasm: auto, none, gzip, br, or both")
+
+func compressionsToProduce() []string {
+	switch *flagCompress {
+	case "none":
+		return nil
+	case "gzip":
+		return []string{"gzip"}
+	case "br":
+		return []string{"br"}
+	case "auto", "both":
+		return []string{"gzip", "br"}
+	default:
+		return []string{"gzip", "br"}
+	}
+}
+
+func ensureCompressed(wasmPath string) error {
+	for _, enc := range compressionsToProduce() {
+		out := wasmPath + compressedExt(enc)
+		if _, err := os.Stat(out); err == nil {
+			continue
+		}
+		if err := compressFile(wasmPath, out, enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressedExt(enc string) string {
+	switch enc {
+	case "br":
+		return ".br"
+	default:
+		return ".gz"
+	}
+}
+
+func compressFile(src, dst, enc string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	switch enc {
+	case "br":
+		bw := brotli.NewWriterLevel(out, brotli.DefaultCompression)
+		if _, err := io.Copy(bw, in); err != nil {
+			return err
+		}
+		return bw.Close()
+	default:
+		gw := gzip.NewWriter(out)
+		if _, err := io.Copy(gw, in); err != nil {
+			return err
+		}
+		return gw.Close()
+	}
+}
+
+func negotiateEncoding(r *http.Request, wasmPath string) string {
+	if *flagCompress == "none" {
+		return ""
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	if strings.Contains(accept, "br") {
+		if _, err := os.Stat(wasmPath + ".br"); err == nil {
+			return "br"
+		}
+	}
+	if strings.Contains(accept, "gzip") {
+		if _, err := os.Stat(wasmPath + ".gz"); err == nil {
+			return "gzip"
+		}
+	}
+	return ""
+}
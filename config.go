@@ -0,0 +1,164 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var flagConfig = flag.String("config", "", "Path to a YAML or JSON file mapping URL prefixes to packages, for serving multiple wasm entries from one instance")
+
+type routeConfig struct {
+	Prefix   string   `json:"prefix" yaml:"prefix"`
+	Package  string   `json:"package" yaml:"package"`
+	Tags     string   `json:"tags" yaml:"tags"`
+	Overlay  string   `json:"overlay" yaml:"overlay"`
+	Target   string   `json:"target" yaml:"target"`
+	Compiler string   `json:"compiler" yaml:"compiler"`
+	Env      []string `json:"env" yaml:"env"`
+}
+
+type routingTable struct {
+	Routes []routeConfig `json:"routes" yaml:"routes"`
+}
+
+var (
+	routingTableOnce sync.Once
+	loadedRoutes     []routeConfig
+	routingTableErr  error
+)
+
+func loadRoutingTable() ([]routeConfig, error) {
+	routingTableOnce.Do(func() {
+		if *flagConfig == "" {
+			return
+		}
+		b, err := os.ReadFile(*flagConfig)
+		if err != nil {
+			routingTableErr = err
+			return
+		}
+
+		var table routingTable
+		if strings.HasSuffix(*flagConfig, ".json") {
+			err = json.Unmarshal(b, &table)
+		} else {
+			err = yaml.Unmarshal(b, &table)
+		}
+		if err != nil {
+			routingTableErr = err
+			return
+		}
+
+		routes := table.Routes
+		sort.Slice(routes, func(i, j int) bool {
+			return len(routes[i].Prefix) > len(routes[j].Prefix)
+		})
+		loadedRoutes = routes
+	})
+	return loadedRoutes, routingTableErr
+}
+
+func matchRoute(upath string) (*routeConfig, bool) {
+	routes, err := loadRoutingTable()
+	if err != nil || len(routes) == 0 {
+		return nil, false
+	}
+	p := "/" + upath
+	for i, rt := range routes {
+		if p == rt.Prefix || strings.HasPrefix(p, rt.Prefix+"/") {
+			return &routes[i], true
+		}
+	}
+	return nil, false
+}
+
+type resolvedRoute struct {
+	Key      string
+	Package  string
+	Tags     string
+	Overlay  string
+	Target   string
+	Compiler string
+	Env      []string
+}
+
+func resolveRoute(r *http.Request) *resolvedRoute {
+	upath := r.URL.Path[1:]
+	if rt, ok := matchRoute(upath); ok {
+		pkg := rt.Package
+		if pkg == "" {
+			pkg = "."
+		}
+		target := rt.Target
+		if target == "" {
+			target = "js"
+		}
+		compiler := rt.Compiler
+		if compiler == "" {
+			compiler = "go"
+		}
+		return &resolvedRoute{
+			Key:      rt.Prefix,
+			Package:  pkg,
+			Tags:     rt.Tags,
+			Overlay:  rt.Overlay,
+			Target:   target,
+			Compiler: compiler,
+			Env:      rt.Env,
+		}
+	}
+
+	pkg := "."
+	if flag.NArg() > 0 {
+		pkg = flag.Args()[0]
+	}
+	return &resolvedRoute{
+		Key:      "default",
+		Package:  pkg,
+		Tags:     *flagTags,
+		Overlay:  *flagOverlay,
+		Target:   *flagTarget,
+		Compiler: *flagCompiler,
+	}
+}
+
+func (rt *resolvedRoute) outputDir() (string, error) {
+	return ensureTmpOutputDir(rt.Key)
+}
+
+func (rt *resolvedRoute) root() string {
+	return filepath.Clean(rt.Package)
+}
+
+func (rt *resolvedRoute) relativePath(urlPath string) string {
+	if rt.Key == "default" {
+		return urlPath
+	}
+	rest := strings.TrimPrefix(urlPath, rt.Key)
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	return rest
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// liveReloadEvent is sent to connected browsers over the /_livereload
+// WebSocket. Type is either "reload" or "build_error".
+type liveReloadEvent struct {
+	Type   string `json:"type"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+var liveReloadUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+var (
+	liveReloadMu      sync.Mutex
+	liveReloadClients = map[*websocket.Conn]bool{}
+)
+
+// handleLiveReload upgrades the request to a WebSocket connection and
+// keeps it registered as a live-reload client until it disconnects.
+func handleLiveReload(w http.ResponseWriter, r *http.Request) {
+	conn, err := liveReloadUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	liveReloadMu.Lock()
+	liveReloadClients[conn] = true
+	liveReloadMu.Unlock()
+
+	defer func() {
+		liveReloadMu.Lock()
+		delete(liveReloadClients, conn)
+		liveReloadMu.Unlock()
+		conn.Close()
+	}()
+
+	// Drain and discard client messages; this connection only needs to
+	// stay open long enough to detect disconnects.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// broadcastLiveReload sends ev to every connected browser, dropping any
+// connection that errors.
+func broadcastLiveReload(ev liveReloadEvent) {
+	liveReloadMu.Lock()
+	defer liveReloadMu.Unlock()
+
+	for conn := range liveReloadClients {
+		if err := conn.WriteJSON(ev); err != nil {
+			conn.Close()
+			delete(liveReloadClients, conn)
+		}
+	}
+}
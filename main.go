@@ -24,10 +24,10 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -35,12 +35,20 @@ import (
 const indexHTML = `<!DOCTYPE html>
 <script src="wasm_exec.js"></script>
 <script>
+function showBuildError(text) {
+  let pre = document.getElementById('wasmserve-build-error');
+  if (!pre) {
+    pre = document.createElement('pre');
+    pre.id = 'wasmserve-build-error';
+    document.body.appendChild(pre);
+  }
+  pre.innerText = text;
+}
+
 (async () => {
   const resp = await fetch('main.wasm');
   if (!resp.ok) {
-    const pre = document.createElement('pre');
-    pre.innerText = await resp.text();
-    document.body.appendChild(pre);
+    showBuildError(await resp.text());
   } else {
     const src = await resp.arrayBuffer();
     const go = new Go();
@@ -49,11 +57,22 @@ const indexHTML = `<!DOCTYPE html>
     go.env = {{.Env}};
     go.run(result.instance);
   }
-  const reload = await fetch('_wait');
-  // The server sends a response for '_wait' when a request is sent to '_notify'.
-  if (reload.ok) {
-    location.reload();
-  }
+})();
+
+(function connectLiveReload() {
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(proto + '//' + location.host + '/_livereload');
+  ws.onmessage = (msg) => {
+    const ev = JSON.parse(msg.data);
+    if (ev.type === 'reload') {
+      location.reload();
+    } else if (ev.type === 'build_error') {
+      showBuildError(ev.stderr);
+    }
+  };
+  ws.onclose = () => {
+    setTimeout(connectLiveReload, 1000);
+  };
 })();
 </script>
 `
@@ -66,21 +85,27 @@ var (
 )
 
 var (
-	tmpOutputDir = ""
-	waitChannel  = make(chan struct{})
+	tmpOutputDirsMu sync.Mutex
+	tmpOutputDirs   = map[string]string{}
 )
 
-func ensureTmpOutputDir() (string, error) {
-	if tmpOutputDir != "" {
-		return tmpOutputDir, nil
+// ensureTmpOutputDir returns the tmp output directory for the given route
+// key, creating one on first use. Each routing table entry (see -config)
+// gets its own directory so their build caches never collide.
+func ensureTmpOutputDir(key string) (string, error) {
+	tmpOutputDirsMu.Lock()
+	defer tmpOutputDirsMu.Unlock()
+
+	if dir, ok := tmpOutputDirs[key]; ok {
+		return dir, nil
 	}
 
 	tmp, err := ioutil.TempDir("", "")
 	if err != nil {
 		return "", err
 	}
-	tmpOutputDir = tmp
-	return tmpOutputDir, nil
+	tmpOutputDirs[key] = tmp
+	return tmp, nil
 }
 
 func handle(w http.ResponseWriter, r *http.Request) {
@@ -88,16 +113,19 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", *flagAllowOrigin)
 	}
 
-	output, err := ensureTmpOutputDir()
+	route := resolveRoute(r)
+	output, err := route.outputDir()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	upath := r.URL.Path[1:]
-	fpath := path.Base(upath)
+	relPath := route.relativePath(r.URL.Path)
+	upath := relPath[1:]
+	base := path.Base(upath)
+	fpath := filepath.Join(route.root(), base)
 
-	if !strings.HasSuffix(r.URL.Path, "/") {
+	if !strings.HasSuffix(relPath, "/") {
 		fi, err := os.Stat(fpath)
 		if err != nil && !os.IsNotExist(err) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -109,7 +137,7 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	switch filepath.Base(fpath) {
+	switch base {
 	case ".":
 		fpath = filepath.Join(fpath, "index.html")
 		fallthrough
@@ -118,7 +146,10 @@ func handle(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else if errors.Is(err, fs.ErrNotExist) {
-			fargs := flag.Args()
+			var fargs []string
+			if route.Key == "default" {
+				fargs = flag.Args()
+			}
 			if len(fargs) == 0 {
 				fargs = []string{filepath.Join(output, "main.wasm")}
 			}
@@ -126,9 +157,9 @@ func handle(w http.ResponseWriter, r *http.Request) {
 			for _, a := range fargs {
 				argv = append(argv, `"`+template.JSEscapeString(a)+`"`)
 			}
-			h := strings.ReplaceAll(indexHTML, "{{.Argv}}", "["+strings.Join(argv, ", ")+"]")
+			h := strings.ReplaceAll(indexHTMLForTarget(route.Target), "{{.Argv}}", "["+strings.Join(argv, ", ")+"]")
 
-			oenv := os.Environ()
+			oenv := append(append([]string{}, os.Environ()...), route.Env...)
 			env := make([]string, 0, len(oenv))
 			for _, e := range oenv {
 				split := strings.SplitN(e, "=", 2)
@@ -144,13 +175,12 @@ func handle(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else if errors.Is(err, fs.ErrNotExist) {
-			out, err := exec.Command("go", "env", "GOROOT").Output()
+			f, err := wasmExecJS(route)
 			if err != nil {
 				log.Print(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			f := filepath.Join(strings.TrimSpace(string(out)), "misc", "wasm", "wasm_exec.js")
 			http.ServeFile(w, r, f)
 			return
 		}
@@ -159,79 +189,64 @@ func handle(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else if errors.Is(err, fs.ErrNotExist) {
-			// `go run -exec cp <pkg> <output>` is used instead of the equivalent `go build -o <output> <pkg>`
-			// This is to support path@version syntax.
-			// A combination of GOBIN and `go install` would not work due to:
-			// go: cannot install cross-compiled binaries when GOBIN is set
-			exc, err := os.Executable()
+			wasmPath, info, err := buildCachedWasm(route, output)
 			if err != nil {
-				log.Print(err)
+				broadcastLiveReload(liveReloadEvent{Type: "build_error", Stderr: err.Error()})
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			args := []string{"run", "-exec", exc}
-			if *flagTags != "" {
-				args = append(args, "-tags", *flagTags)
-			}
-			if *flagOverlay != "" {
-				args = append(args, "-overlay", *flagOverlay)
-			}
-			if flag.NArg() > 0 {
-				args = append(args, flag.Args()[0])
-			} else {
-				args = append(args, ".")
+			if err := ensureCompressed(wasmPath); err != nil {
+				log.Print("compress: ", err)
 			}
-			args = append(args, filepath.Join(output, "main.wasm"))
-			log.Print("go ", strings.Join(args, " "))
-			cmdRun := exec.Command("go", args...)
-			cmdRun.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm", "WASMSERVE=cp")
-			cmdRun.Dir = "."
-			out, err := cmdRun.CombinedOutput()
-			if err != nil {
-				log.Print(err)
-				log.Print(string(out))
-				http.Error(w, string(out), http.StatusInternalServerError)
-				return
-			}
-			if len(out) > 0 {
-				log.Print(string(out))
+
+			servePath, serveInfo := wasmPath, info
+			w.Header().Set("Vary", "Accept-Encoding")
+			if enc := negotiateEncoding(r, wasmPath); enc != "" {
+				servePath = wasmPath + compressedExt(enc)
+				if si, err := os.Stat(servePath); err == nil {
+					serveInfo = si
+					w.Header().Set("Content-Encoding", enc)
+				} else {
+					servePath = wasmPath
+				}
 			}
 
-			f, err := os.Open(filepath.Join(output, "main.wasm"))
+			f, err := os.Open(servePath)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			defer f.Close()
 
-			http.ServeContent(w, r, "main.wasm", time.Now(), f)
+			w.Header().Set("ETag", `"`+filepath.Base(servePath)+`"`)
+			http.ServeContent(w, r, "main.wasm", serveInfo.ModTime(), f)
 			return
 		}
-	case "_wait":
-		waitForUpdate(w, r)
+	case "_wasi_shim.js":
+		http.ServeContent(w, r, "_wasi_shim.js", time.Time{}, bytes.NewReader(wasiShimJS))
+		return
+	case "_livereload":
+		handleLiveReload(w, r)
 		return
 	case "_notify":
 		notifyWaiters(w, r)
 		return
 	}
 
-	http.ServeFile(w, r, filepath.Join(".", r.URL.Path))
+	http.ServeFile(w, r, filepath.Join(route.root(), relPath))
 }
 
-func waitForUpdate(w http.ResponseWriter, r *http.Request) {
-	waitChannel <- struct{}{}
+// notifyWaiters is kept as an HTTP trigger for scripts and CI that can't
+// hold a WebSocket connection open; it broadcasts the same "reload" event
+// a filesystem-watch-triggered reload would.
+func notifyWaiters(w http.ResponseWriter, r *http.Request) {
+	triggerReload()
 	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(nil))
 }
 
-func notifyWaiters(w http.ResponseWriter, r *http.Request) {
-	for {
-		select {
-		case <-waitChannel:
-		default:
-			http.ServeContent(w, r, "", time.Now(), bytes.NewReader(nil))
-			return
-		}
-	}
+// triggerReload notifies any connected browsers to reload.
+func triggerReload() {
+	broadcastLiveReload(liveReloadEvent{Type: "reload"})
 }
 
 func main() {
@@ -259,6 +274,8 @@ func main() {
 		return
 	}
 
+	startWatching()
+
 	http.HandleFunc("/", handle)
 	log.Fatal(http.ListenAndServe(*flagHTTP, nil))
 }
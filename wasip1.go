@@ -0,0 +1,82 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"flag"
+)
+
+//go:embed assets/wasi_shim.js
+var wasiShimJS []byte
+
+var flagTarget = flag.String("target", "js", "Build target: js (browser JS runtime) or wasip1 (WASI, run against an in-browser polyfill)")
+
+const indexHTMLWasip1 = `<!DOCTYPE html>
+<script src="_wasi_shim.js"></script>
+<script>
+function showBuildError(text) {
+  let pre = document.getElementById('wasmserve-build-error');
+  if (!pre) {
+    pre = document.createElement('pre');
+    pre.id = 'wasmserve-build-error';
+    document.body.appendChild(pre);
+  }
+  pre.innerText = text;
+}
+
+(async () => {
+  const resp = await fetch('main.wasm');
+  if (!resp.ok) {
+    showBuildError(await resp.text());
+  } else {
+    const src = await resp.arrayBuffer();
+    const wasi = new WASI({{.Argv}});
+    const module = await WebAssembly.instantiate(src, wasi.importObject);
+    wasi.start(module.instance);
+  }
+})();
+
+(function connectLiveReload() {
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(proto + '//' + location.host + '/_livereload');
+  ws.onmessage = (msg) => {
+    const ev = JSON.parse(msg.data);
+    if (ev.type === 'reload') {
+      location.reload();
+    } else if (ev.type === 'build_error') {
+      showBuildError(ev.stderr);
+    }
+  };
+  ws.onclose = () => {
+    setTimeout(connectLiveReload, 1000);
+  };
+})();
+</script>
+`
+
+func indexHTMLForTarget(target string) string {
+	if target == "wasip1" {
+		return indexHTMLWasip1
+	}
+	return indexHTML
+}
+
+func routeGOOS(route *resolvedRoute) string {
+	if route.Target == "wasip1" {
+		return "wasip1"
+	}
+	return "js"
+}
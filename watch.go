@@ -0,0 +1,148 @@
+// Copyright 2018 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	flagWatch         = flag.Bool("watch", true, "Watch source files and automatically reload the browser on change")
+	flagWatchDebounce = flag.Duration("watch-debounce", 300*time.Millisecond, "Debounce interval for batching watch events")
+	flagWatchInclude  = flag.String("watch-include", "*.go,*.mod,*.sum,*.html", "Comma-separated globs of files that trigger a reload when changed")
+	flagWatchExclude  = flag.String("watch-exclude", "", "Comma-separated globs of files to ignore even if they match -watch-include")
+)
+
+func watchRoots() []string {
+	roots := []string{"."}
+	if flag.NArg() > 0 {
+		roots = []string{flag.Args()[0]}
+	}
+	if *flagOverlay != "" {
+		roots = append(roots, filepath.Dir(*flagOverlay))
+	}
+
+	if routes, err := loadRoutingTable(); err == nil {
+		for _, rt := range routes {
+			if rt.Package != "" {
+				roots = append(roots, rt.Package)
+			}
+			if rt.Overlay != "" {
+				roots = append(roots, filepath.Dir(rt.Overlay))
+			}
+		}
+	}
+
+	return roots
+}
+
+func matchesGlobList(name, list string) bool {
+	if list == "" {
+		return false
+	}
+	for _, pat := range strings.Split(list, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pat, filepath.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func shouldTriggerReload(name string) bool {
+	if matchesGlobList(name, *flagWatchExclude) {
+		return false
+	}
+	return matchesGlobList(name, *flagWatchInclude)
+}
+
+func startWatching() {
+	if !*flagWatch {
+		return
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Print("watch: ", err)
+		return
+	}
+
+	for _, root := range watchRoots() {
+		if err := addRecursive(w, root); err != nil {
+			log.Print("watch: ", err)
+		}
+	}
+
+	go runWatchLoop(w)
+}
+
+func addRecursive(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && p != root {
+				return filepath.SkipDir
+			}
+			return w.Add(p)
+		}
+		return nil
+	})
+}
+
+func runWatchLoop(w *fsnotify.Watcher) {
+	defer w.Close()
+
+	var timer *time.Timer
+	reload := make(chan struct{}, 1)
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if !shouldTriggerReload(ev.Name) {
+				continue
+			}
+			if timer == nil {
+				timer = time.AfterFunc(*flagWatchDebounce, func() {
+					reload <- struct{}{}
+				})
+			} else {
+				timer.Reset(*flagWatchDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Print("watch: ", err)
+		case <-reload:
+			log.Print("watch: change detected, notifying browsers")
+			triggerReload()
+		}
+	}
+}